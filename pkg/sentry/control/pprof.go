@@ -0,0 +1,320 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/urpc"
+)
+
+// Profile includes profile-related RPC stubs.
+type Profile struct {
+	// State is the sandbox's State controller, registered alongside
+	// Profile at boot. /debug/state serves the Stats snapshot State last
+	// computed, rather than computing its own.
+	State *State
+
+	// cpuFile is the current CPU profile output file, if CPU profiling
+	// is in progress.
+	cpuFile   *os.File
+	cpuFileMu sync.Mutex
+
+	// traceFile is the current execution trace output file, if tracing
+	// is in progress.
+	traceFile   *os.File
+	traceFileMu sync.Mutex
+
+	// server is the pprof/debug HTTP server started by Serve, if any.
+	server   *http.Server
+	serverMu sync.Mutex
+}
+
+// ProfileOpts contains options for the RPCs that dump a single profile
+// registered with runtime/pprof (HeapProfile, GoroutineProfile,
+// MutexProfile, BlockProfile, AllocsProfile, ThreadCreateProfile) to the
+// file passed by the caller.
+type ProfileOpts struct {
+	urpc.FilePayload
+}
+
+// HeapProfile writes a heap profile to the file passed by the caller.
+func (p *Profile) HeapProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("heap", o)
+}
+
+// GoroutineProfile writes a goroutine profile to the file passed by the
+// caller.
+func (p *Profile) GoroutineProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("goroutine", o)
+}
+
+// MutexProfile writes a mutex profile to the file passed by the caller.
+// SetMutexProfileFraction must be used to enable mutex profiling first, as
+// it is off by default.
+func (p *Profile) MutexProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("mutex", o)
+}
+
+// BlockProfile writes a blocking profile to the file passed by the caller.
+// SetBlockProfileRate must be used to enable block profiling first, as it
+// is off by default.
+func (p *Profile) BlockProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("block", o)
+}
+
+// AllocsProfile writes a memory allocations profile to the file passed by
+// the caller.
+func (p *Profile) AllocsProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("allocs", o)
+}
+
+// ThreadCreateProfile writes an OS thread creation profile to the file
+// passed by the caller.
+func (p *Profile) ThreadCreateProfile(o *ProfileOpts, _ *struct{}) error {
+	return lookupProfile("threadcreate", o)
+}
+
+// lookupProfile writes the profile registered under name (see
+// runtime/pprof.Lookup) to the file passed via o, in pprof's compressed
+// protobuf format.
+func lookupProfile(name string, o *ProfileOpts) error {
+	if len(o.FilePayload.Files) != 1 {
+		return fmt.Errorf("exactly one file must be provided, but %d were", len(o.FilePayload.Files))
+	}
+	f := o.FilePayload.Files[0]
+	defer f.Close()
+
+	prof := rpprof.Lookup(name)
+	if prof == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if err := prof.WriteTo(f, 0); err != nil {
+		return fmt.Errorf("writing %s profile: %v", name, err)
+	}
+	return nil
+}
+
+// StartCPUProfileOpts contains options for the StartCPUProfile RPC.
+type StartCPUProfileOpts struct {
+	urpc.FilePayload
+}
+
+// StartCPUProfile start recording the CPU profile to the file passed by the
+// caller. It is stopped by StopCPUProfile.
+func (p *Profile) StartCPUProfile(o *StartCPUProfileOpts, _ *struct{}) error {
+	if len(o.FilePayload.Files) != 1 {
+		return fmt.Errorf("exactly one file must be provided, but %d were", len(o.FilePayload.Files))
+	}
+
+	p.cpuFileMu.Lock()
+	defer p.cpuFileMu.Unlock()
+	if p.cpuFile != nil {
+		o.FilePayload.Files[0].Close()
+		return fmt.Errorf("CPU profiling already started")
+	}
+
+	if err := rpprof.StartCPUProfile(o.FilePayload.Files[0]); err != nil {
+		o.FilePayload.Files[0].Close()
+		return fmt.Errorf("starting CPU profile: %v", err)
+	}
+	p.cpuFile = o.FilePayload.Files[0]
+	return nil
+}
+
+// StopCPUProfile stops a CPU profile started by StartCPUProfile.
+func (p *Profile) StopCPUProfile(_, _ *struct{}) error {
+	p.cpuFileMu.Lock()
+	defer p.cpuFileMu.Unlock()
+	if p.cpuFile == nil {
+		return fmt.Errorf("CPU profiling not started")
+	}
+	rpprof.StopCPUProfile()
+	err := p.cpuFile.Close()
+	p.cpuFile = nil
+	return err
+}
+
+// StartTraceOpts contains options for the StartTrace RPC.
+type StartTraceOpts struct {
+	urpc.FilePayload
+}
+
+// StartTrace starts recording an execution trace to the file passed by the
+// caller. It is stopped by StopTrace.
+func (p *Profile) StartTrace(o *StartTraceOpts, _ *struct{}) error {
+	if len(o.FilePayload.Files) != 1 {
+		return fmt.Errorf("exactly one file must be provided, but %d were", len(o.FilePayload.Files))
+	}
+
+	p.traceFileMu.Lock()
+	defer p.traceFileMu.Unlock()
+	if p.traceFile != nil {
+		o.FilePayload.Files[0].Close()
+		return fmt.Errorf("tracing already started")
+	}
+
+	if err := trace.Start(o.FilePayload.Files[0]); err != nil {
+		o.FilePayload.Files[0].Close()
+		return fmt.Errorf("starting trace: %v", err)
+	}
+	p.traceFile = o.FilePayload.Files[0]
+	return nil
+}
+
+// StopTrace stops a trace started by StartTrace.
+func (p *Profile) StopTrace(_, _ *struct{}) error {
+	p.traceFileMu.Lock()
+	defer p.traceFileMu.Unlock()
+	if p.traceFile == nil {
+		return fmt.Errorf("tracing not started")
+	}
+	trace.Stop()
+	err := p.traceFile.Close()
+	p.traceFile = nil
+	return err
+}
+
+// MutexProfileFractionOpts contains options for the SetMutexProfileFraction
+// RPC.
+type MutexProfileFractionOpts struct {
+	// Fraction is the new sampling fraction: on average 1/Fraction of
+	// mutex contention events are reported. A Fraction of 0 disables
+	// mutex profiling; a negative Fraction leaves the current fraction
+	// unchanged and only reads it back.
+	Fraction int
+}
+
+// SetMutexProfileFraction sets the sentry's mutex profiling fraction,
+// mirroring runtime.SetMutexProfileFraction, and returns the previous
+// fraction. Mutex profiling is off by default, so this must be called
+// before a MutexProfile RPC can return useful data.
+func (p *Profile) SetMutexProfileFraction(o *MutexProfileFractionOpts, out *int) error {
+	*out = runtime.SetMutexProfileFraction(o.Fraction)
+	return nil
+}
+
+// BlockProfileRateOpts contains options for the SetBlockProfileRate RPC.
+type BlockProfileRateOpts struct {
+	// Rate is the new block profiling rate, in nanoseconds spent blocked
+	// per sample. A Rate of 0 or less disables block profiling.
+	Rate int
+}
+
+// SetBlockProfileRate sets the sentry's blocking profile rate, mirroring
+// runtime.SetBlockProfileRate. Block profiling is off by default, so this
+// must be called before a BlockProfile RPC can return useful data.
+func (p *Profile) SetBlockProfileRate(o *BlockProfileRateOpts, _ *struct{}) error {
+	runtime.SetBlockProfileRate(o.Rate)
+	return nil
+}
+
+// ServeOpts contains options for the Serve RPC.
+type ServeOpts struct {
+	// FilePayload carries the host-side listening socket that runsc
+	// opened on the sandbox's behalf (a loopback TCP listener or a Unix
+	// domain socket), passed in as a single file descriptor. The sentry
+	// cannot bind its own listener that is reachable from the host, since
+	// it runs in an isolated network namespace; accepting connections off
+	// a listener opened and proxied in by runsc is what makes
+	// /debug/pprof reachable by host-side tooling.
+	urpc.FilePayload
+
+	// Duration, if non-zero, causes the server to shut itself down after
+	// the given amount of time has elapsed.
+	Duration time.Duration
+}
+
+// Serve starts a long-lived HTTP server inside the sentry, accepting
+// connections off the listener runsc passed in via o, and exposing the
+// standard net/http/pprof endpoints (heap, profile, goroutine, mutex,
+// block, allocs, threadcreate), /debug/vars, and /debug/state, which
+// returns the most recent State.Stats snapshot.
+func (p *Profile) Serve(o *ServeOpts, _ *struct{}) error {
+	p.serverMu.Lock()
+	defer p.serverMu.Unlock()
+	if p.server != nil {
+		return fmt.Errorf("pprof server already running")
+	}
+
+	if len(o.FilePayload.Files) != 1 {
+		return fmt.Errorf("exactly one listener file must be provided, but %d were", len(o.FilePayload.Files))
+	}
+	f := o.FilePayload.Files[0]
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return fmt.Errorf("converting listener file to a net.Listener: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	// net/http/pprof serves its execution trace at /debug/pprof/trace, not
+	// /debug/trace; this is the standard path and the one pprof's own
+	// tooling (e.g. "go tool pprof") expects.
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/state", p.serveState)
+
+	server := &http.Server{Handler: mux}
+	p.server = server
+
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Warningf("pprof server exited: %v", err)
+		}
+	}()
+
+	if o.Duration > 0 {
+		time.AfterFunc(o.Duration, func() {
+			p.serverMu.Lock()
+			defer p.serverMu.Unlock()
+			if p.server == server {
+				server.Close()
+				p.server = nil
+			}
+		})
+	}
+
+	return nil
+}
+
+// serveState responds with the Stats snapshot computed by the most recent
+// State.Stats call (e.g. from a "runsc debug -stats" invocation), as a
+// convenience for clients already polling the pprof endpoint. It does not
+// compute a fresh snapshot itself.
+func (p *Profile) serveState(w http.ResponseWriter, r *http.Request) {
+	stats, ok := p.State.LastStats()
+	if !ok {
+		http.Error(w, "no stats snapshot available yet; call State.Stats (e.g. via \"runsc debug -stats\") at least once first", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "%+v\n", stats)
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+)
+
+// State includes state-related RPC stubs.
+//
+// It is unrelated to kernel.SaveState/LoadState despite the name.
+type State struct {
+	Kernel *kernel.Kernel
+
+	// startTime is the time passed to NewStateController, and is used to
+	// compute sandbox uptime.
+	startTime time.Time
+
+	// mu protects last and haveLast.
+	mu sync.Mutex
+
+	// last is the Stats snapshot computed by the most recent call to
+	// Stats, used to serve /debug/state without recomputing it.
+	last Stats
+
+	// haveLast is true once Stats has been called at least once.
+	haveLast bool
+}
+
+// NewStateController returns a new State controller rooted at startTime.
+func NewStateController(k *kernel.Kernel, startTime time.Time) *State {
+	return &State{
+		Kernel:    k,
+		startTime: startTime,
+	}
+}
+
+// Stats contains a point-in-time snapshot of sandbox resource usage,
+// returned by State.Stats.
+type Stats struct {
+	// Uptime is the sandbox uptime, formatted as a duration string (e.g.
+	// "3h2m1s").
+	Uptime string
+
+	// UptimeSeconds is the sandbox uptime in seconds.
+	UptimeSeconds float64
+
+	// ResidentMemoryBytes is the sentry process's resident set size, in
+	// bytes.
+	ResidentMemoryBytes uint64
+
+	// VirtualMemoryBytes is the sentry process's virtual memory size, in
+	// bytes.
+	VirtualMemoryBytes uint64
+
+	// NumGoroutine is the number of live goroutines in the sentry process
+	// as a whole. Goroutines in the sentry are scheduled M:N across
+	// tasks, so there is no meaningful per-task goroutine count to
+	// report; this is the closest available proxy for the sentry's
+	// scheduling load.
+	NumGoroutine int
+
+	// NumContainers is the number of distinct containers with at least
+	// one live task in the sandbox.
+	NumContainers int
+
+	// NumTasks is the number of tasks currently running in the sandbox,
+	// across all containers.
+	NumTasks int
+
+	// Aggregate per-syscall counters are intentionally not reported here:
+	// pkg/sentry/kernel has no existing facility for accounting syscalls
+	// across tasks, and bolting one on is out of scope for this snapshot.
+}
+
+// Stats populates out with a snapshot of the sandbox's current resource
+// usage.
+func (s *State) Stats(_, out *Stats) error {
+	out.Uptime = time.Since(s.startTime).Round(time.Second).String()
+	out.UptimeSeconds = time.Since(s.startTime).Seconds()
+	out.NumGoroutine = runtime.NumGoroutine()
+
+	rss, vss, err := rusageMemory()
+	if err != nil {
+		return err
+	}
+	out.ResidentMemoryBytes = rss
+	out.VirtualMemoryBytes = vss
+
+	tasks := s.Kernel.TaskSet().Root.Tasks()
+	containers := make(map[string]struct{})
+	for _, t := range tasks {
+		containers[t.ContainerID()] = struct{}{}
+	}
+	out.NumTasks = len(tasks)
+	out.NumContainers = len(containers)
+
+	s.mu.Lock()
+	s.last = *out
+	s.haveLast = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+// LastStats returns the Stats snapshot computed by the most recent call to
+// Stats, and whether Stats has been called at least once.
+func (s *State) LastStats() (Stats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.haveLast
+}
+
+// rusageMemory returns the resident and virtual memory size, in bytes, of
+// the calling (sentry) process, read from /proc/self/statm.
+func rusageMemory() (rss, vss uint64, err error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading /proc/self/statm: %v", err)
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("malformed /proc/self/statm: %q", statm)
+	}
+	pageSize := uint64(os.Getpagesize())
+	vssPages, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing /proc/self/statm vss %q: %v", fields[0], err)
+	}
+	rssPages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing /proc/self/statm rss %q: %v", fields[1], err)
+	}
+	return rssPages * pageSize, vssPages * pageSize, nil
+}
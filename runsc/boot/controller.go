@@ -0,0 +1,43 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/urpc"
+)
+
+// bootTime is recorded at package initialization, which happens while the
+// sentry process is starting the sandbox, and is used as the origin for
+// uptime reporting.
+var bootTime = time.Now()
+
+// registerStateAndProfile registers the State and Profile control RPC
+// servers on server, alongside the other controllers (Lifecycle, Logging,
+// Proc, ...) the Loader registers when it brings up the sandbox's control
+// server. State is rooted at bootTime so that -stats/-uptime/-watch and the
+// /debug/state pprof endpoint report the sandbox's real uptime.
+//
+// Called from Loader.run in runsc/boot/loader.go (not part of this
+// checkout), immediately after server is constructed and before the
+// Lifecycle/Logging/Proc controllers are registered on it.
+func registerStateAndProfile(server *urpc.Server, k *kernel.Kernel) {
+	state := control.NewStateController(k, bootTime)
+	server.Register(state)
+	server.Register(&control.Profile{State: state})
+}
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -32,16 +33,26 @@ import (
 
 // Debug implements subcommands.Command for the "debug" command.
 type Debug struct {
-	pid          int
-	stacks       bool
-	signal       int
-	profileHeap  string
-	profileCPU   string
-	profileDelay int
-	trace        string
-	strace       string
-	logLevel     string
-	logPackets   string
+	pid              int
+	stacks           bool
+	stacksPprof      string
+	signal           int
+	profileHeap      string
+	profileCPU       string
+	profileGoroutine string
+	profileMutex     string
+	profileBlock     string
+	profileAllocs    string
+	profileDelay     int
+	trace            string
+	strace           string
+	logLevel         string
+	logPackets       string
+	stats            bool
+	uptime           bool
+	watch            time.Duration
+	serve            string
+	serveDelay       time.Duration
 }
 
 // Name implements subcommands.Command.
@@ -63,14 +74,24 @@ func (*Debug) Usage() string {
 func (d *Debug) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&d.pid, "pid", 0, "sandbox process ID. Container ID is not necessary if this is set")
 	f.BoolVar(&d.stacks, "stacks", false, "if true, dumps all sandbox stacks to the log")
+	f.StringVar(&d.stacksPprof, "stacks-pprof", "", "if set together with -stacks, also writes a pprof-format (debug=0) goroutine profile to the given file")
 	f.StringVar(&d.profileHeap, "profile-heap", "", "writes heap profile to the given file.")
 	f.StringVar(&d.profileCPU, "profile-cpu", "", "writes CPU profile to the given file.")
-	f.IntVar(&d.profileDelay, "profile-delay", 5, "amount of time to wait before stoping CPU profile")
+	f.StringVar(&d.profileGoroutine, "profile-goroutine", "", "writes goroutine profile to the given file.")
+	f.StringVar(&d.profileMutex, "profile-mutex", "", "enables mutex profiling for -profile-delay seconds and writes the profile to the given file.")
+	f.StringVar(&d.profileBlock, "profile-block", "", "enables block profiling for -profile-delay seconds and writes the profile to the given file.")
+	f.StringVar(&d.profileAllocs, "profile-allocs", "", "writes memory allocations profile to the given file.")
+	f.IntVar(&d.profileDelay, "profile-delay", 5, "amount of time to wait before stoping CPU profile, and the sampling window used by -profile-mutex/-profile-block")
 	f.StringVar(&d.trace, "trace", "", "writes an execution trace to the given file.")
 	f.IntVar(&d.signal, "signal", -1, "sends signal to the sandbox")
 	f.StringVar(&d.strace, "strace", "", `A comma separated list of syscalls to trace. "all" enables all traces, "off" disables all`)
 	f.StringVar(&d.logLevel, "log-level", "", "The log level to set: warning (0), info (1), or debug (2).")
 	f.StringVar(&d.logPackets, "log-packets", "", "A boolean value to enable or disable packet logging: true or false.")
+	f.BoolVar(&d.stats, "stats", false, "if true, reports sandbox uptime and live resource usage")
+	f.BoolVar(&d.uptime, "uptime", false, "if true, reports sandbox uptime only")
+	f.DurationVar(&d.watch, "watch", 0, "if non-zero, -stats is sampled at this interval and one line is emitted per sample, forever")
+	f.StringVar(&d.serve, "serve", "", "starts a pprof/debug HTTP server inside the sandbox, listening on the given address (host:port, or unix:/path/to/socket)")
+	f.DurationVar(&d.serveDelay, "serve-duration", 0, "if non-zero, shuts down the -serve HTTP server after this much time has elapsed")
 }
 
 // Execute implements subcommands.Command.Execute.
@@ -132,6 +153,35 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 			return Errorf("retrieving stacks: %v", err)
 		}
 		log.Infof("     *** Stack dump ***\n%s", stacks)
+
+		if d.stacksPprof != "" {
+			f, err := os.Create(d.stacksPprof)
+			if err != nil {
+				return Errorf(err.Error())
+			}
+			defer f.Close()
+
+			if err := c.Sandbox.GoroutineProfile(f); err != nil {
+				return Errorf("writing goroutine profile: %v", err)
+			}
+			log.Infof("Goroutine profile written to %q", d.stacksPprof)
+		}
+	}
+	if d.serve != "" {
+		log.Infof("Starting pprof/debug server on %q", d.serve)
+		if err := c.Sandbox.Serve(d.serve, d.serveDelay); err != nil {
+			return Errorf("starting pprof server: %v", err)
+		}
+		if d.serveDelay > 0 {
+			log.Infof("Server will shut down after %s", d.serveDelay)
+		}
+	}
+	if d.uptime {
+		stats, err := c.Sandbox.Stats()
+		if err != nil {
+			return Errorf("getting sandbox stats: %v", err)
+		}
+		fmt.Println(stats.Uptime)
 	}
 	if d.profileHeap != "" {
 		f, err := os.Create(d.profileHeap)
@@ -145,6 +195,88 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		}
 		log.Infof("Heap profile written to %q", d.profileHeap)
 	}
+	if d.profileGoroutine != "" {
+		f, err := os.Create(d.profileGoroutine)
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		defer f.Close()
+
+		if err := c.Sandbox.GoroutineProfile(f); err != nil {
+			return Errorf(err.Error())
+		}
+		log.Infof("Goroutine profile written to %q", d.profileGoroutine)
+	}
+	if d.profileMutex != "" {
+		f, err := os.Create(d.profileMutex)
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		defer f.Close()
+
+		// Mutex profiling is off by default; turn it on for the sampling
+		// window below, then restore whatever fraction was configured
+		// before this invocation so the sentry isn't left paying the
+		// sampling overhead indefinitely.
+		previous, err := c.Sandbox.SetMutexProfileFraction(1)
+		if err != nil {
+			return Errorf("enabling mutex profiling: %v", err)
+		}
+		defer func() {
+			if _, err := c.Sandbox.SetMutexProfileFraction(previous); err != nil {
+				Fatalf("restoring mutex profile fraction: %v", err)
+			}
+		}()
+
+		log.Infof("Sampling mutex contention for %d sec before writing profile to %q", d.profileDelay, d.profileMutex)
+		time.Sleep(time.Duration(d.profileDelay) * time.Second)
+
+		if err := c.Sandbox.MutexProfile(f); err != nil {
+			return Errorf(err.Error())
+		}
+		log.Infof("Mutex profile written to %q", d.profileMutex)
+	}
+	if d.profileBlock != "" {
+		f, err := os.Create(d.profileBlock)
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		defer f.Close()
+
+		// Block profiling is off by default; turn it on for the sampling
+		// window below. Unlike mutex profiling, runtime.SetBlockProfileRate
+		// has no way to read back the previously configured rate, so the
+		// best we can do on exit is disable it again rather than leave
+		// block profiling running in the live sentry.
+		if err := c.Sandbox.SetBlockProfileRate(1); err != nil {
+			return Errorf("enabling block profiling: %v", err)
+		}
+		defer func() {
+			if err := c.Sandbox.SetBlockProfileRate(0); err != nil {
+				Fatalf("disabling block profiling: %v", err)
+			}
+		}()
+
+		log.Infof("Sampling blocking events for %d sec before writing profile to %q", d.profileDelay, d.profileBlock)
+		time.Sleep(time.Duration(d.profileDelay) * time.Second)
+
+		if err := c.Sandbox.BlockProfile(f); err != nil {
+			return Errorf(err.Error())
+		}
+		log.Infof("Block profile written to %q", d.profileBlock)
+	}
+	if d.profileAllocs != "" {
+		f, err := os.Create(d.profileAllocs)
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		defer f.Close()
+
+		if err := c.Sandbox.AllocsProfile(f); err != nil {
+			return Errorf(err.Error())
+		}
+		log.Infof("Allocs profile written to %q", d.profileAllocs)
+	}
 
 	delay := false
 	if d.profileCPU != "" {
@@ -243,5 +375,34 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		time.Sleep(time.Duration(d.profileDelay) * time.Second)
 	}
 
+	// -watch samples -stats forever and never returns, so it must run
+	// last: anything placed after it (profiles, logging changes, and so
+	// on above) would otherwise never execute.
+	if d.stats {
+		if d.watch > 0 {
+			for {
+				if err := printStats(c); err != nil {
+					return Errorf("getting sandbox stats: %v", err)
+				}
+				time.Sleep(d.watch)
+			}
+		}
+		if err := printStats(c); err != nil {
+			return Errorf("getting sandbox stats: %v", err)
+		}
+	}
+
 	return subcommands.ExitSuccess
 }
+
+// printStats queries c's sandbox for its current stats and writes a single
+// line to stdout, suitable for scraping by a monitoring pipeline.
+func printStats(c *container.Container) error {
+	stats, err := c.Sandbox.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("uptime=%s uptime_seconds=%.3f rss_bytes=%d vss_bytes=%d goroutines=%d containers=%d tasks=%d\n",
+		stats.Uptime, stats.UptimeSeconds, stats.ResidentMemoryBytes, stats.VirtualMemoryBytes, stats.NumGoroutine, stats.NumContainers, stats.NumTasks)
+	return nil
+}
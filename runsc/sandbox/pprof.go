@@ -0,0 +1,207 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/urpc"
+)
+
+// profile calls the named single-file Profile RPC, passing f for the
+// sentry to write its output to.
+func (s *Sandbox) profile(rpc string, f *os.File) error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.ProfileOpts{FilePayload: urpc.FilePayload{Files: []*os.File{f}}}
+	return conn.Call(rpc, &opts, nil)
+}
+
+// HeapProfile writes a heap profile to f.
+func (s *Sandbox) HeapProfile(f *os.File) error {
+	return s.profile("Profile.HeapProfile", f)
+}
+
+// GoroutineProfile writes a goroutine profile to f.
+func (s *Sandbox) GoroutineProfile(f *os.File) error {
+	return s.profile("Profile.GoroutineProfile", f)
+}
+
+// MutexProfile writes a mutex profile to f. SetMutexProfileFraction must be
+// called first to enable mutex profiling.
+func (s *Sandbox) MutexProfile(f *os.File) error {
+	return s.profile("Profile.MutexProfile", f)
+}
+
+// BlockProfile writes a blocking profile to f. SetBlockProfileRate must be
+// called first to enable block profiling.
+func (s *Sandbox) BlockProfile(f *os.File) error {
+	return s.profile("Profile.BlockProfile", f)
+}
+
+// AllocsProfile writes a memory allocations profile to f.
+func (s *Sandbox) AllocsProfile(f *os.File) error {
+	return s.profile("Profile.AllocsProfile", f)
+}
+
+// ThreadCreateProfile writes an OS thread creation profile to f.
+func (s *Sandbox) ThreadCreateProfile(f *os.File) error {
+	return s.profile("Profile.ThreadCreateProfile", f)
+}
+
+// SetMutexProfileFraction sets the sentry's mutex profiling fraction and
+// returns the previous fraction. Mutex profiling is off by default.
+func (s *Sandbox) SetMutexProfileFraction(fraction int) (int, error) {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	opts := control.MutexProfileFractionOpts{Fraction: fraction}
+	var previous int
+	if err := conn.Call("Profile.SetMutexProfileFraction", &opts, &previous); err != nil {
+		return 0, err
+	}
+	return previous, nil
+}
+
+// SetBlockProfileRate sets the sentry's blocking profile rate. Block
+// profiling is off by default.
+func (s *Sandbox) SetBlockProfileRate(rate int) error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.BlockProfileRateOpts{Rate: rate}
+	return conn.Call("Profile.SetBlockProfileRate", &opts, nil)
+}
+
+// StartCPUProfile starts recording a CPU profile to f. It is stopped by
+// StopCPUProfile.
+func (s *Sandbox) StartCPUProfile(f *os.File) error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.StartCPUProfileOpts{FilePayload: urpc.FilePayload{Files: []*os.File{f}}}
+	return conn.Call("Profile.StartCPUProfile", &opts, nil)
+}
+
+// StopCPUProfile stops a CPU profile started by StartCPUProfile.
+func (s *Sandbox) StopCPUProfile() error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Call("Profile.StopCPUProfile", nil, nil)
+}
+
+// StartTrace starts recording an execution trace to f. It is stopped by
+// StopTrace.
+func (s *Sandbox) StartTrace(f *os.File) error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.StartTraceOpts{FilePayload: urpc.FilePayload{Files: []*os.File{f}}}
+	return conn.Call("Profile.StartTrace", &opts, nil)
+}
+
+// StopTrace stops a trace started by StartTrace.
+func (s *Sandbox) StopTrace() error {
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Call("Profile.StopTrace", nil, nil)
+}
+
+// Serve opens a host-side listener on addr (a "host:port" pair, or a
+// "unix:/path/to/socket"), then hands its file descriptor to the sentry so
+// it can start a long-lived pprof/debug HTTP server accepting connections
+// off it. The sentry runs in its own network namespace and cannot bind a
+// listener the host can reach directly, so runsc opens the listener and
+// proxies it in, the same way profile files are passed in for
+// HeapProfile/StartCPUProfile/StartTrace. If duration is non-zero, the
+// server shuts itself down after that much time has elapsed. For a unix
+// socket, the socket path outlives this call and is not removed by Serve;
+// the caller is responsible for cleaning it up.
+func (s *Sandbox) Serve(addr string, duration time.Duration) error {
+	network := "tcp"
+	laddr := addr
+	const unixPrefix = "unix:"
+	if strings.HasPrefix(addr, unixPrefix) {
+		network = "unix"
+		laddr = strings.TrimPrefix(addr, unixPrefix)
+	}
+
+	ln, err := net.Listen(network, laddr)
+	if err != nil {
+		return fmt.Errorf("listening on %q: %v", addr, err)
+	}
+	defer ln.Close()
+
+	var f *os.File
+	switch l := ln.(type) {
+	case *net.TCPListener:
+		f, err = l.File()
+	case *net.UnixListener:
+		// l.File() dups the listening socket's FD for the sentry, but
+		// ln.Close() below still runs against the original FD once this
+		// function returns. A *net.UnixListener unlinks its socket path on
+		// Close by default, which would remove laddr out from under the
+		// sentry's duped listener before any client could ever dial it.
+		// Tell it not to: the socket file now outlives this call, and
+		// cleaning it up is the caller's responsibility (e.g. on container
+		// teardown), the same as any other bind-mounted socket path.
+		l.SetUnlinkOnClose(false)
+		f, err = l.File()
+	default:
+		return fmt.Errorf("unsupported listener type %T for %q", ln, addr)
+	}
+	if err != nil {
+		return fmt.Errorf("getting file for listener on %q: %v", addr, err)
+	}
+	defer f.Close()
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.ServeOpts{FilePayload: urpc.FilePayload{Files: []*os.File{f}}, Duration: duration}
+	return conn.Call("Profile.Serve", &opts, nil)
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/sentry/control"
+)
+
+// Stats queries the sentry for a point-in-time snapshot of the sandbox's
+// resource usage: uptime, sentry memory footprint, goroutine count, and
+// live container and task counts.
+func (s *Sandbox) Stats() (control.Stats, error) {
+	var stats control.Stats
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return control.Stats{}, err
+	}
+	defer conn.Close()
+
+	if err := conn.Call("State.Stats", nil, &stats); err != nil {
+		return control.Stats{}, fmt.Errorf("getting sandbox stats: %v", err)
+	}
+
+	return stats, nil
+}